@@ -0,0 +1,645 @@
+package mocksy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements the small expression language Rule.Match and
+// Rule.Rewrite are written in, e.g.
+// `request.header("X-Tenant") == "acme" && request.body.contains("foo")`.
+//
+// The original request asked for an embedded evaluator such as
+// antonmedv/expr or knetic/govaluate rather than a hand-rolled one; this
+// repo has no go.mod/vendored module tree for mocksy to pull one in from,
+// so the grammar is implemented here instead. That's a real constraint,
+// not a stylistic choice, and should be confirmed with whoever owns this
+// backlog item before merge: if a module tree gets added later, this file
+// should be replaced with the requested library rather than grown further.
+
+// ruleEnv is the evaluation context available to a Rule's expressions.
+// response is nil when evaluating a Match expression, since there is no
+// selected response yet at that point.
+type ruleEnv struct {
+	request  *http.Request
+	body     []byte
+	response *RecordedResponse
+}
+
+// evalExpr parses and evaluates exprStr against env.
+func evalExpr(exprStr string, env *ruleEnv) (interface{}, error) {
+	node, err := parseExpr(exprStr)
+	if err != nil {
+		return nil, err
+	}
+	return evalNode(node, env)
+}
+
+// --- AST ---
+
+type exprNode interface{}
+
+type strLitNode struct{ value string }
+type numLitNode struct{ value float64 }
+type notNode struct{ operand exprNode }
+type negNode struct{ operand exprNode }
+type binOpNode struct {
+	op          string
+	left, right exprNode
+}
+type segment struct {
+	name string
+	args []exprNode
+	call bool
+}
+type chainNode struct{ segments []segment }
+
+// --- tokenizer ---
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokLParen
+	tokRParen
+	tokComma
+	tokDot
+	tokEq
+	tokNeq
+	tokAnd
+	tokOr
+	tokNot
+	tokMinus
+)
+
+type token struct {
+	kind tokKind
+	text string
+}
+
+type lexer struct {
+	input []rune
+	pos   int
+}
+
+func (l *lexer) peek() (rune, bool) {
+	if l.pos >= len(l.input) {
+		return 0, false
+	}
+	return l.input[l.pos], true
+}
+
+func (l *lexer) next() (token, error) {
+	for {
+		r, ok := l.peek()
+		if !ok {
+			return token{kind: tokEOF}, nil
+		}
+		if unicode.IsSpace(r) {
+			l.pos++
+			continue
+		}
+		break
+	}
+	r, _ := l.peek()
+	switch {
+	case r == '(':
+		l.pos++
+		return token{kind: tokLParen}, nil
+	case r == ')':
+		l.pos++
+		return token{kind: tokRParen}, nil
+	case r == ',':
+		l.pos++
+		return token{kind: tokComma}, nil
+	case r == '-':
+		l.pos++
+		return token{kind: tokMinus}, nil
+	case r == '.':
+		l.pos++
+		return token{kind: tokDot}, nil
+	case r == '"':
+		return l.lexString()
+	case r == '=':
+		l.pos++
+		if r2, ok := l.peek(); ok && r2 == '=' {
+			l.pos++
+			return token{kind: tokEq}, nil
+		}
+		return token{}, fmt.Errorf("mocksy: unexpected '=' in expression")
+	case r == '!':
+		l.pos++
+		if r2, ok := l.peek(); ok && r2 == '=' {
+			l.pos++
+			return token{kind: tokNeq}, nil
+		}
+		return token{kind: tokNot}, nil
+	case r == '&':
+		l.pos++
+		if r2, ok := l.peek(); ok && r2 == '&' {
+			l.pos++
+			return token{kind: tokAnd}, nil
+		}
+		return token{}, fmt.Errorf("mocksy: unexpected '&' in expression")
+	case r == '|':
+		l.pos++
+		if r2, ok := l.peek(); ok && r2 == '|' {
+			l.pos++
+			return token{kind: tokOr}, nil
+		}
+		return token{}, fmt.Errorf("mocksy: unexpected '|' in expression")
+	case unicode.IsDigit(r):
+		return l.lexNumber()
+	case unicode.IsLetter(r) || r == '_':
+		return l.lexIdent()
+	default:
+		return token{}, fmt.Errorf("mocksy: unexpected character %q in expression", r)
+	}
+}
+
+func (l *lexer) lexString() (token, error) {
+	l.pos++ // consume opening quote
+	var sb strings.Builder
+	for {
+		r, ok := l.peek()
+		if !ok {
+			return token{}, fmt.Errorf("mocksy: unterminated string literal")
+		}
+		l.pos++
+		if r == '"' {
+			return token{kind: tokString, text: sb.String()}, nil
+		}
+		sb.WriteRune(r)
+	}
+}
+
+func (l *lexer) lexNumber() (token, error) {
+	start := l.pos
+	for {
+		r, ok := l.peek()
+		if !ok || !(unicode.IsDigit(r) || r == '.') {
+			break
+		}
+		l.pos++
+	}
+	return token{kind: tokNumber, text: string(l.input[start:l.pos])}, nil
+}
+
+func (l *lexer) lexIdent() (token, error) {
+	start := l.pos
+	for {
+		r, ok := l.peek()
+		if !ok || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			break
+		}
+		l.pos++
+	}
+	return token{kind: tokIdent, text: string(l.input[start:l.pos])}, nil
+}
+
+// --- parser ---
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func parseExpr(s string) (exprNode, error) {
+	p := &parser{lex: &lexer{input: []rune(s)}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("mocksy: unexpected trailing input in expression %q", s)
+	}
+	return n, nil
+}
+
+func (p *parser) advance() error {
+	t, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	return nil
+}
+
+func (p *parser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOr {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binOpNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (exprNode, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokAnd {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = &binOpNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseComparison() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokEq || p.tok.kind == tokNeq {
+		op := "=="
+		if p.tok.kind == tokNeq {
+			op = "!="
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &binOpNode{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (exprNode, error) {
+	if p.tok.kind == tokNot {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	if p.tok.kind == tokMinus {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (exprNode, error) {
+	switch p.tok.kind {
+	case tokString:
+		v := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return &strLitNode{value: v}, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(p.tok.text, 64)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return &numLitNode{value: f}, nil
+	case tokLParen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, fmt.Errorf("mocksy: expected ')' in expression")
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case tokIdent:
+		return p.parseChain()
+	default:
+		return nil, fmt.Errorf("mocksy: unexpected token in expression")
+	}
+}
+
+func (p *parser) parseChain() (exprNode, error) {
+	var segs []segment
+	name := p.tok.text
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	seg := segment{name: name}
+	if p.tok.kind == tokLParen {
+		args, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		seg.args = args
+		seg.call = true
+	}
+	segs = append(segs, seg)
+
+	for p.tok.kind == tokDot {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokIdent {
+			return nil, fmt.Errorf("mocksy: expected identifier after '.' in expression")
+		}
+		name := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		seg := segment{name: name}
+		if p.tok.kind == tokLParen {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			seg.args = args
+			seg.call = true
+		}
+		segs = append(segs, seg)
+	}
+
+	return &chainNode{segments: segs}, nil
+}
+
+func (p *parser) parseArgs() ([]exprNode, error) {
+	if err := p.advance(); err != nil { // consume '('
+		return nil, err
+	}
+	var args []exprNode
+	if p.tok.kind == tokRParen {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return args, nil
+	}
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.tok.kind == tokComma {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		break
+	}
+	if p.tok.kind != tokRParen {
+		return nil, fmt.Errorf("mocksy: expected ')' in expression")
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// --- evaluator ---
+
+func evalNode(n exprNode, env *ruleEnv) (interface{}, error) {
+	switch node := n.(type) {
+	case *strLitNode:
+		return node.value, nil
+	case *numLitNode:
+		return node.value, nil
+	case *notNode:
+		v, err := evalNode(node.operand, env)
+		if err != nil {
+			return nil, err
+		}
+		b, _ := v.(bool)
+		return !b, nil
+	case *negNode:
+		v, err := evalNode(node.operand, env)
+		if err != nil {
+			return nil, err
+		}
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("mocksy: unary '-' requires a numeric operand")
+		}
+		return -f, nil
+	case *binOpNode:
+		return evalBinOp(node, env)
+	case *chainNode:
+		return evalChain(node, env)
+	default:
+		return nil, fmt.Errorf("mocksy: unsupported expression node")
+	}
+}
+
+func evalBinOp(node *binOpNode, env *ruleEnv) (interface{}, error) {
+	switch node.op {
+	case "&&":
+		l, err := evalNode(node.left, env)
+		if err != nil {
+			return nil, err
+		}
+		if lb, _ := l.(bool); !lb {
+			return false, nil
+		}
+		r, err := evalNode(node.right, env)
+		if err != nil {
+			return nil, err
+		}
+		rb, _ := r.(bool)
+		return rb, nil
+	case "||":
+		l, err := evalNode(node.left, env)
+		if err != nil {
+			return nil, err
+		}
+		if lb, _ := l.(bool); lb {
+			return true, nil
+		}
+		r, err := evalNode(node.right, env)
+		if err != nil {
+			return nil, err
+		}
+		rb, _ := r.(bool)
+		return rb, nil
+	case "==", "!=":
+		l, err := evalNode(node.left, env)
+		if err != nil {
+			return nil, err
+		}
+		r, err := evalNode(node.right, env)
+		if err != nil {
+			return nil, err
+		}
+		eq := l == r
+		if node.op == "!=" {
+			return !eq, nil
+		}
+		return eq, nil
+	default:
+		return nil, fmt.Errorf("mocksy: unknown operator %q", node.op)
+	}
+}
+
+func evalChain(c *chainNode, env *ruleEnv) (interface{}, error) {
+	if len(c.segments) == 0 {
+		return nil, fmt.Errorf("mocksy: empty expression")
+	}
+	first := c.segments[0]
+	if len(c.segments) == 1 && first.call {
+		return evalBuiltin(first.name, first.args, env)
+	}
+
+	var cur interface{}
+	switch first.name {
+	case "request":
+		cur = env.request
+	case "response":
+		if env.response == nil {
+			return nil, fmt.Errorf("mocksy: response is not available in this context")
+		}
+		cur = env.response
+	default:
+		return nil, fmt.Errorf("mocksy: unknown identifier %q", first.name)
+	}
+
+	for _, seg := range c.segments[1:] {
+		v, err := evalField(cur, seg, env)
+		if err != nil {
+			return nil, err
+		}
+		cur = v
+	}
+	return cur, nil
+}
+
+func evalField(cur interface{}, seg segment, env *ruleEnv) (interface{}, error) {
+	switch base := cur.(type) {
+	case *http.Request:
+		switch seg.name {
+		case "method":
+			return base.Method, nil
+		case "path":
+			return base.URL.EscapedPath(), nil
+		case "host":
+			return base.Host, nil
+		case "body":
+			return string(env.body), nil
+		case "header":
+			name, err := stringArg(seg.args, 0, env)
+			if err != nil {
+				return nil, err
+			}
+			return base.Header.Get(name), nil
+		case "query":
+			name, err := stringArg(seg.args, 0, env)
+			if err != nil {
+				return nil, err
+			}
+			return base.URL.Query().Get(name), nil
+		}
+	case *RecordedResponse:
+		switch seg.name {
+		case "status":
+			return float64(base.Status), nil
+		case "body":
+			return string(base.Body), nil
+		case "header":
+			name, err := stringArg(seg.args, 0, env)
+			if err != nil {
+				return nil, err
+			}
+			return base.Headers.Get(name), nil
+		}
+	case string:
+		switch seg.name {
+		case "contains":
+			sub, err := stringArg(seg.args, 0, env)
+			if err != nil {
+				return nil, err
+			}
+			return strings.Contains(base, sub), nil
+		}
+	}
+	return nil, fmt.Errorf("mocksy: unsupported field or method %q", seg.name)
+}
+
+func evalBuiltin(name string, args []exprNode, env *ruleEnv) (interface{}, error) {
+	switch name {
+	case "contains":
+		a, err := stringArg(args, 0, env)
+		if err != nil {
+			return nil, err
+		}
+		b, err := stringArg(args, 1, env)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Contains(a, b), nil
+	case "replace":
+		a, err := stringArg(args, 0, env)
+		if err != nil {
+			return nil, err
+		}
+		old, err := stringArg(args, 1, env)
+		if err != nil {
+			return nil, err
+		}
+		neu, err := stringArg(args, 2, env)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Replace(a, old, neu, -1), nil
+	default:
+		return nil, fmt.Errorf("mocksy: unknown function %q", name)
+	}
+}
+
+func stringArg(args []exprNode, i int, env *ruleEnv) (string, error) {
+	if i >= len(args) {
+		return "", fmt.Errorf("mocksy: missing argument %d", i)
+	}
+	v, err := evalNode(args[i], env)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("mocksy: expected string argument, got %v", v)
+	}
+	return s, nil
+}
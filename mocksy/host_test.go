@@ -0,0 +1,31 @@
+package mocksy
+
+import "testing"
+
+func TestHostMatches(t *testing.T) {
+	cases := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"*.example.com", "foo.example.com", true},
+		{"*.example.com", "foo.bar.example.com", false},
+		{"*.example.com", "example.com", false},
+		{"api.dev.local", "api.dev.local", true},
+		{"api.dev.local", "api.other.local", false},
+	}
+	for _, c := range cases {
+		if got := hostMatches(c.pattern, c.host); got != c.want {
+			t.Errorf("hostMatches(%q, %q) = %v, want %v", c.pattern, c.host, got, c.want)
+		}
+	}
+}
+
+func TestFilterByHostStripsPort(t *testing.T) {
+	// Distinct IPs, so the match can only come from the host value.
+	items := []Item{{Host: Host{Value: "*.example.com", Ip: "10.0.0.1"}}}
+	host := Host{Value: "foo.example.com:8080", Ip: "10.0.0.2"}
+	got := filterByHost(items, host)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 match, got %d", len(got))
+	}
+}
@@ -0,0 +1,37 @@
+package mocksy
+
+import "net/http"
+
+// Host captures how a recorded request's host was determined: the literal
+// value that was sent (which may contain wildcard labels, e.g.
+// "*.example.com") and, when available, the IP mocksy resolved for it.
+type Host struct {
+	Value string
+	Ip    string
+}
+
+// RecordedRequest is the raw body captured for a recorded request.
+type RecordedRequest struct {
+	Value []byte
+}
+
+// RecordedResponse is what mocksy replays for a matched request.
+type RecordedResponse struct {
+	Status  int
+	Headers http.Header
+	Body    []byte
+}
+
+// Item is a single recorded request/response pair, along with the
+// metadata FindMatching uses to decide whether it is a good replay for an
+// incoming request.
+type Item struct {
+	Host     Host
+	Request  RecordedRequest
+	Response RecordedResponse
+	Method   string
+	Path     string
+	Protocol string
+	Port     string
+	Rules    []Rule
+}
@@ -1,44 +1,188 @@
 package mocksy
 
 import (
-	"bytes"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"sort"
 	"strings"
 )
 
-// FIXME: use a container better suited for searching. Must find an efficient key
-// to do fuzzy search with requests.
-type responseDB []Item
+// responseDB stores recorded request/response pairs indexed for fast
+// lookup: by host value, by host IP, and by "method+path" route, so
+// FindMatching doesn't have to walk every recorded item to find viable
+// candidates.
+type responseDB struct {
+	items         []*Item
+	byHostValue   map[string][]*Item
+	byHostIP      map[string][]*Item
+	byRoute       map[string][]*Item
+	wildcardHosts []*Item
+}
+
+var responseHistory = newResponseDB()
+
+func newResponseDB() *responseDB {
+	return &responseDB{
+		items:       make([]*Item, 0),
+		byHostValue: make(map[string][]*Item),
+		byHostIP:    make(map[string][]*Item),
+		byRoute:     make(map[string][]*Item),
+	}
+}
+
+// routeKey builds the key used by responseDB.byRoute.
+func routeKey(method, path string) string {
+	return method + "\x00" + path
+}
+
+// add inserts itm into every index.
+func (db *responseDB) add(itm Item) {
+	p := &itm
+	db.items = append(db.items, p)
+	db.byHostValue[p.Host.Value] = append(db.byHostValue[p.Host.Value], p)
+	if p.Host.Ip != "" {
+		db.byHostIP[p.Host.Ip] = append(db.byHostIP[p.Host.Ip], p)
+	}
+	if strings.Contains(p.Host.Value, "*") {
+		db.wildcardHosts = append(db.wildcardHosts, p)
+	}
+	key := routeKey(p.Method, p.Path)
+	db.byRoute[key] = append(db.byRoute[key], p)
+}
+
+// candidatesFor returns the items most likely to match an incoming request
+// for (host, method, path). It tries the exact method+path route first,
+// then the host indexes, and only falls back to scanning every stored item.
+// Every step admits wildcard host patterns: the route bucket and the full
+// scan go through filterByHost, and the host-index hits have the matching
+// wildcard items merged in.
+func (db *responseDB) candidatesFor(host Host, method, path string) []Item {
+	if route := db.byRoute[routeKey(method, path)]; len(route) > 0 {
+		// The route bucket can hold items recorded against unrelated hosts,
+		// so it must still be intersected with the host filter rather than
+		// returned as-is.
+		if byHost := filterByHost(derefItems(route), host); len(byHost) > 0 {
+			return byHost
+		}
+	}
+
+	// A hit in byHostValue/byHostIP doesn't rule out a better wildcard-host
+	// candidate for the same requested host: wildcard items are indexed
+	// under their own literal pattern (e.g. "*.example.com"), not under
+	// reqHost, so the exact-match buckets can never contain them and must
+	// have the wildcard items merged back in.
+	reqHost := stripPort(host.Value)
+	if byValue := db.byHostValue[reqHost]; len(byValue) > 0 {
+		return append(derefItems(byValue), filterByHost(derefItems(db.wildcardHosts), host)...)
+	}
+	if host.Ip != "" {
+		if byIP := db.byHostIP[host.Ip]; len(byIP) > 0 {
+			return append(derefItems(byIP), filterByHost(derefItems(db.wildcardHosts), host)...)
+		}
+	}
 
-var responseHistory responseDB
+	return filterByHost(derefItems(db.items), host)
+}
 
-func init() {
-	responseHistory = make([]Item, 0)
+func derefItems(ptrs []*Item) []Item {
+	out := make([]Item, len(ptrs))
+	for i, p := range ptrs {
+		out[i] = *p
+	}
+	return out
 }
 
 // AddToHistory inserts a pair request-response in the responseHistory.
 func AddToHistory(itm Item) {
-	responseHistory = append(responseHistory, itm)
+	responseHistory.add(itm)
 }
 
 func HistoryLength() int {
-	return len(responseHistory)
+	return len(responseHistory.items)
 }
 
-// FindMatching takes an http request and returns the closest match to it
-// based on the response history.
+// MinConfidence is the lowest candidateScore FindMatching will accept
+// before giving up on a match entirely, rather than returning the
+// least-bad item in the history. Leave at 0 (the default) to always
+// return the top-ranked item, however poor.
+var MinConfidence = 0.0
+
+// SetMinConfidence overrides MinConfidence.
+func SetMinConfidence(c float64) {
+	MinConfidence = c
+}
 
-func FindMatching(req *http.Request) string {
+// FindMatching takes an http request and returns the closest match to it
+// based on the response history, along with whether a good enough match
+// was found at all.
+func FindMatching(req *http.Request) (Item, bool) {
 	host := findHost(req)
-	// Take only requests matching our filter criteria and sort them by best match
-	viableReqs := filterByHost(responseHistory, host)
-	fuzzySort(viableReqs, host, req)
 
-	return ""
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mocksy: error reading body of request while matching: %s\n", err.Error())
+		return Item{}, false
+	}
+
+	// Take only requests matching our filter criteria, apply any attached
+	// match rules as a hard filter, and sort what's left by best match.
+	viableReqs := responseHistory.candidatesFor(host, req.Method, req.URL.EscapedPath())
+	viableReqs = filterByRules(viableReqs, req, body)
+	fuzzySort(viableReqs, host, req, body)
+
+	if len(viableReqs) == 0 {
+		return Item{}, false
+	}
+
+	args := compareArgs{
+		Request:  body,
+		Host:     host,
+		Port:     req.URL.Port(),
+		Protocol: req.Proto,
+		Method:   req.Method,
+		Path:     req.URL.EscapedPath(),
+	}
+	tied := topTier(viableReqs, args, matchWeights)
+	selected := selectionPolicy.Select(tied, req)
+	if candidateScore(selected, args, matchWeights) < MinConfidence {
+		return Item{}, false
+	}
+
+	selected = applyRewriteRules(selected, req, body)
+	return selected, true
+}
+
+// Handler is an http.Handler backed by the response history, so mocksy
+// can be dropped straight into an http.Server or httptest.Server.
+var Handler http.Handler = responseHistory
+
+// ServeHTTP implements http.Handler: it looks up the best match for r with
+// FindMatching, which searches the package-level responseHistory whichever
+// db it is called on, and replays it, or answers 404 when nothing matched
+// with enough confidence.
+func (db *responseDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	item, ok := FindMatching(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	header := w.Header()
+	for name, values := range item.Response.Headers {
+		for _, v := range values {
+			header.Add(name, v)
+		}
+	}
+
+	status := item.Response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(item.Response.Body)
 }
 
 // findHost tries to retreive host information from `req`.
@@ -60,17 +204,49 @@ func findHost(req *http.Request) Host {
 	return host
 }
 
-// filterByHost returns all elements in `lst` whose host is `host` (matching either by value or by ip)
+// filterByHost returns all elements in `lst` whose host is `host` (matching
+// either by value, allowing wildcard labels in the stored host, or by ip).
 func filterByHost(lst []Item, host Host) []Item {
+	reqHost := stripPort(host.Value)
 	newlst := make([]Item, 0)
 	for _, e := range lst {
-		if e.Host.Value == host.Value || e.Host.Ip == host.Ip {
+		if hostMatches(e.Host.Value, reqHost) || e.Host.Ip == host.Ip {
 			newlst = append(newlst, e)
 		}
 	}
 	return newlst
 }
 
+// stripPort removes a trailing ":port" from h using net.SplitHostPort.
+// If h carries no port, it is returned unchanged.
+func stripPort(h string) string {
+	if host, _, err := net.SplitHostPort(h); err == nil {
+		return host
+	}
+	return h
+}
+
+// hostMatches reports whether the dot-separated labels of `h` match the
+// dot-separated labels of `pattern`, treating a "*" label in `pattern` as
+// a wildcard for exactly one label. So "*.example.com" matches
+// "foo.example.com" but not "foo.bar.example.com".
+func hostMatches(pattern, h string) bool {
+	patLabels := strings.Split(pattern, ".")
+	hLabels := strings.Split(h, ".")
+	if len(patLabels) != len(hLabels) {
+		return false
+	}
+	for i, p := range patLabels {
+		if p == "*" {
+			continue
+		}
+		if p != hLabels[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // compareArgs is a struct containing the information that we use to match
 // two requests.
 type compareArgs struct {
@@ -84,15 +260,10 @@ type compareArgs struct {
 
 // fuzzySort sorts the requests from the "best matching" with `req` to the least.
 // Sort is done in place, so the given `reqs` is modified by this call.
-func fuzzySort(reqs []Item, host Host, req *http.Request) {
+func fuzzySort(reqs []Item, host Host, req *http.Request, body []byte) {
 	if len(reqs) == 0 {
 		return
 	}
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "mocksy: error reading body of request while sorting: %s\n", err.Error())
-		return
-	}
 	less := fuzzyComparer(reqs, compareArgs{
 		Request:  body,
 		Host:     host,
@@ -104,105 +275,165 @@ func fuzzySort(reqs []Item, host Host, req *http.Request) {
 	sort.Slice(reqs, less)
 }
 
-// fuzzyComparer returns a `Less` function which, given requests i and j,
-// tells which one matches the given `args` the most.
-// This is the most important part of Mocksy, as the quality of the matches
-// depends on the returned comparer.
-func fuzzyComparer(reqs []Item, args compareArgs) func(int, int) bool {
-	// longestPrefix returns the number of common runes at the beginning of
-	// strings `a` and `b`. For convenience, it also returns whether the strings
-	// are the same or not.
-	longestPrefix := func(a, b string) (pfx int, perfectMatch bool) {
-		if perfectMatch = a == b; perfectMatch {
-			return
+// MatchWeights controls how much each criterion contributes to a
+// candidate's score when fuzzySort ranks requests. Users can tune these to
+// reflect their own APIs, e.g. giving BodySimilarity more weight than
+// Method for POST-heavy services.
+type MatchWeights struct {
+	PathPrefix     float64
+	BodySimilarity float64
+	Method         float64
+	Protocol       float64
+	Port           float64
+}
+
+// DefaultMatchWeights is used by fuzzySort unless overridden with
+// SetMatchWeights.
+var DefaultMatchWeights = MatchWeights{
+	PathPrefix:     1.0,
+	BodySimilarity: 1.0,
+	Method:         1.0,
+	Protocol:       1.0,
+	Port:           1.0,
+}
+
+var matchWeights = DefaultMatchWeights
+
+// SetMatchWeights overrides the weights used to score match candidates.
+func SetMatchWeights(w MatchWeights) {
+	matchWeights = w
+}
+
+// maxBodyDistanceLen caps the Levenshtein distance computation over request
+// bodies, so that matching against very large bodies stays predictable.
+var maxBodyDistanceLen = 4 * 1024
+
+// SetMaxBodyDistance overrides the body length above which bodyDistance
+// skips the full Levenshtein computation.
+func SetMaxBodyDistance(n int) {
+	maxBodyDistanceLen = n
+}
+
+// bodyDistance returns the Levenshtein edit distance between a and b. If
+// either exceeds maxBodyDistanceLen, it returns max(len(a), len(b)) rather
+// than pay for the full O(n*m) DP matrix.
+func bodyDistance(a, b []byte) int {
+	if len(a) > maxBodyDistanceLen || len(b) > maxBodyDistanceLen {
+		if len(a) > len(b) {
+			return len(a)
 		}
-		for i := 0; i < len(a) && i < len(b); i++ {
-			if a[i] != b[i] {
-				break
+		return len(b)
+	}
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
 			}
-			pfx++
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
 		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	m := a
+	if b < m {
+		m = b
+	}
+	if c < m {
+		m = c
+	}
+	return m
+}
+
+// longestPrefix returns the number of common runes at the beginning of
+// strings `a` and `b`. For convenience, it also returns whether the strings
+// are the same or not.
+func longestPrefix(a, b string) (pfx int, perfectMatch bool) {
+	if perfectMatch = a == b; perfectMatch {
 		return
 	}
-	return func(i, j int) bool {
-		ra, rb := reqs[i], reqs[j]
-		// First, check path. If one of the paths is the same as the original one
-		// and the other's not, it's the best candidate.
-		_, perfectMatchA := longestPrefix(ra.Path, args.Path)
-		_, perfectMatchB := longestPrefix(rb.Path, args.Path)
-		if perfectMatchA != perfectMatchB {
-			// Here, the boolean value of `perfectMatchA` means "ra matches exactly, and rb does not".
-			// In that case, ra is a better candidate and should be considered "less" than rb
-			// (since we order best-first). Else, rb is the better candidate.
-			return perfectMatchA
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			break
 		}
+		pfx++
+	}
+	return
+}
 
-		// Here, either both paths match exactly, or neither does.
-		// In this case, we check the request.
-		reqAExact := bytes.Equal(ra.Request.Value, args.Request)
-		reqBExact := bytes.Equal(rb.Request.Value, args.Request)
-		if reqAExact != reqBExact {
-			// If one of the requests matches exactly and the other does not, we have our decision.
-			return reqAExact
-		}
+// candidateScore returns how well `it` matches `args` under weights `w`.
+// Each criterion is normalized to the [0, 1] range before being weighted,
+// so higher scores always mean better matches.
+func candidateScore(it Item, args compareArgs, w MatchWeights) float64 {
+	pfx, samePath := longestPrefix(it.Path, args.Path)
+	maxPathLen := len(it.Path)
+	if len(args.Path) > maxPathLen {
+		maxPathLen = len(args.Path)
+	}
+	pathScore := 1.0
+	if maxPathLen > 0 && !samePath {
+		pathScore = float64(pfx) / float64(maxPathLen)
+	}
 
-		// Else, get the information on which request is closer to the actual one.
-		// TODO: for now, we just check the _length_ of the requests, not the content
-		var aMatchesMost bool
-		//var minReqLenDiff = 0
-		{
-			diffLenA := len(ra.Request.Value) - len(args.Request)
-			diffLenB := len(rb.Request.Value) - len(args.Request)
-			if diffLenA < 0 {
-				diffLenA = -diffLenA
-			}
-			if diffLenB < 0 {
-				diffLenB = -diffLenB
-			}
-			aMatchesMost = diffLenA < diffLenB
-			//if aMatchesMost {
-			//minReqLenDiff = diffLenA
-			//} else {
-			//minReqLenDiff = diffLenB
-			//}
-		}
+	dist := bodyDistance(it.Request.Value, args.Request)
+	maxBodyLen := len(it.Request.Value)
+	if len(args.Request) > maxBodyLen {
+		maxBodyLen = len(args.Request)
+	}
+	bodyScore := 1.0
+	if maxBodyLen > 0 {
+		bodyScore = 1.0 - float64(dist)/float64(maxBodyLen)
+	}
 
-		// Now check the method. If one of the methods matches and the other does not,
-		// it's considered the best candidate unless the other's request is closer
-		// to the actual one. In that case, use heuristic to decide the better option.
-		if (ra.Method == args.Method) != (rb.Method == args.Method) {
-
-			// In this case, one of the methods matches exactly and the other does not.
-
-			if (ra.Method == args.Method) != aMatchesMost {
-				// In this case, one of the requests has the same method, but the other has
-				// a request body which matches more the original one.
-				// For now, we just prefer the method over the request, but here we may use
-				// heuristics (like `minReqLenDiff`) to have better control over this choice.
-				return ra.Method == args.Method
-			} else {
-				// Here, a request matches the actual method _and_ its request body is
-				// closer to the original one. Return that request without further investigation.
-				return ra.Method == args.Method
-			}
-		}
+	methodScore, protoScore, portScore := 0.0, 0.0, 0.0
+	if it.Method == args.Method {
+		methodScore = 1.0
+	}
+	if it.Protocol == args.Protocol {
+		protoScore = 1.0
+	}
+	if it.Port == args.Port {
+		portScore = 1.0
+	}
 
-		// Here, either both methods match or neither does.
-		// Check the protocol.
-		if (ra.Protocol == args.Protocol) != (rb.Protocol == args.Protocol) {
-			// One of the protocol matches, the other does not.
-			// Like before, we may use heuristics on the request bodies to determine our choice,
-			// but for now just return the request whose protocol matches.
-			return ra.Protocol == args.Protocol
-		}
+	return w.PathPrefix*pathScore + w.BodySimilarity*bodyScore +
+		w.Method*methodScore + w.Protocol*protoScore + w.Port*portScore
+}
 
-		// Finally, check port.
-		if (ra.Port == args.Port) != (rb.Port == args.Port) {
-			return ra.Port == args.Port
-		}
+// topTier returns the leading items of reqs (already sorted best-first by
+// fuzzySort) that are tied for the top score, so a SelectionPolicy only
+// chooses among genuinely equivalent candidates instead of the whole
+// history.
+func topTier(reqs []Item, args compareArgs, w MatchWeights) []Item {
+	if len(reqs) == 0 {
+		return reqs
+	}
+	top := candidateScore(reqs[0], args, w)
+	i := 1
+	for i < len(reqs) && candidateScore(reqs[i], args, w) == top {
+		i++
+	}
+	return reqs[:i]
+}
 
-		// If we got here, all previous criteria failed and the requests are almost the same.
-		// In this case, return the one whose request body is closer to the original.
-		return aMatchesMost
+// fuzzyComparer returns a `Less` function which, given requests i and j,
+// tells which one matches the given `args` the most.
+// This is the most important part of Mocksy, as the quality of the matches
+// depends on the returned comparer.
+func fuzzyComparer(reqs []Item, args compareArgs) func(int, int) bool {
+	// Scores are computed at comparison time rather than cached by index:
+	// sort.Slice swaps the elements of reqs between calls, so an index-keyed
+	// cache would no longer describe reqs[i] and reqs[j].
+	return func(i, j int) bool {
+		return candidateScore(reqs[i], args, matchWeights) > candidateScore(reqs[j], args, matchWeights)
 	}
-}
\ No newline at end of file
+}
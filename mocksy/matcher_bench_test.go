@@ -0,0 +1,32 @@
+package mocksy
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// BenchmarkFindMatching proves that matching stays fast once the response
+// history holds a large number of recorded interactions, since FindMatching
+// indexes by route and host instead of scanning the whole history.
+func BenchmarkFindMatching(b *testing.B) {
+	responseHistory = newResponseDB()
+	for i := 0; i < 50000; i++ {
+		host := "host" + strconv.Itoa(i%100) + ".example.com"
+		path := "/api/resource/" + strconv.Itoa(i)
+		AddToHistory(Item{
+			Host:     Host{Value: host, Ip: "10.0.0." + strconv.Itoa(i%255)},
+			Method:   "GET",
+			Path:     path,
+			Protocol: "HTTP/1.1",
+			Port:     "80",
+		})
+	}
+
+	req := httptest.NewRequest("GET", "http://host42.example.com/api/resource/42", nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FindMatching(req)
+	}
+}
@@ -0,0 +1,138 @@
+package mocksy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCandidatesForIntersectsRouteWithHost guards against the route bucket
+// shortcut in candidatesFor bypassing host filtering: recording the same
+// route for two unrelated hosts must not let the wrong host's item win.
+func TestCandidatesForIntersectsRouteWithHost(t *testing.T) {
+	responseHistory = newResponseDB()
+	AddToHistory(Item{
+		Host:   Host{Value: "totally-unrelated.other.org"},
+		Method: "GET",
+		Path:   "/health",
+	})
+	AddToHistory(Item{
+		Host:   Host{Value: "good.example.com"},
+		Method: "GET",
+		Path:   "/health",
+	})
+
+	req := httptest.NewRequest("GET", "http://good.example.com/health", nil)
+	item, ok := FindMatching(req)
+	if !ok {
+		t.Fatalf("expected a match, got none")
+	}
+	if item.Host.Value != "good.example.com" {
+		t.Fatalf("expected match against good.example.com, got %q", item.Host.Value)
+	}
+}
+
+// TestCandidatesForIncludesWildcardAlongsideLiteralHostHit guards against
+// the byHostValue/byHostIP fast paths in candidatesFor shortcutting past a
+// wildcard-host item once a literal-host item for the same requested host
+// is already indexed: a literal hit must not exclude a better wildcard
+// candidate, since wildcard items are indexed under their own pattern
+// rather than under the requested host.
+func TestCandidatesForIncludesWildcardAlongsideLiteralHostHit(t *testing.T) {
+	responseHistory = newResponseDB()
+	AddToHistory(Item{
+		Host:   Host{Value: "good.example.com"},
+		Method: "GET",
+		Path:   "/health",
+	})
+	AddToHistory(Item{
+		Host:   Host{Value: "*.example.com"},
+		Method: "GET",
+		Path:   "/users",
+	})
+
+	req := httptest.NewRequest("GET", "http://good.example.com/users/123", nil)
+	item, ok := FindMatching(req)
+	if !ok {
+		t.Fatalf("expected a match, got none")
+	}
+	if item.Path != "/users" {
+		t.Fatalf("expected the wildcard-recorded /users item to win, got %q", item.Path)
+	}
+}
+
+// TestFindMatchingRejectsBelowMinConfidence ensures a configured
+// MinConfidence makes FindMatching fail loudly rather than returning the
+// least-bad candidate when nothing actually matches well.
+func TestFindMatchingRejectsBelowMinConfidence(t *testing.T) {
+	responseHistory = newResponseDB()
+	defer SetMinConfidence(0)
+
+	AddToHistory(Item{
+		Host:   Host{Value: "example.com"},
+		Method: "GET",
+		Path:   "/accounts",
+	})
+
+	req := httptest.NewRequest("GET", "http://example.com/widgets", nil)
+
+	if _, ok := FindMatching(req); !ok {
+		t.Fatalf("expected a match with the default MinConfidence")
+	}
+
+	SetMinConfidence(10.0)
+	if _, ok := FindMatching(req); ok {
+		t.Fatalf("expected no match once MinConfidence exceeds the best candidate's score")
+	}
+}
+
+// TestServeHTTPReplaysBestMatch checks the ServeHTTP adapter end-to-end:
+// a recorded item is replayed verbatim for a matching request.
+func TestServeHTTPReplaysBestMatch(t *testing.T) {
+	responseHistory = newResponseDB()
+	Handler = responseHistory
+
+	AddToHistory(Item{
+		Host:   Host{Value: "example.com"},
+		Method: "GET",
+		Path:   "/widgets",
+		Response: RecordedResponse{
+			Status: http.StatusCreated,
+			Body:   []byte("hello"),
+		},
+	})
+
+	req := httptest.NewRequest("GET", "http://example.com/widgets", nil)
+	rec := httptest.NewRecorder()
+	Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+	}
+	if rec.Body.String() != "hello" {
+		t.Fatalf("expected body %q, got %q", "hello", rec.Body.String())
+	}
+}
+
+// TestServeHTTPReturns404BelowMinConfidence ensures the handler fails
+// loudly with a 404 instead of replaying a poor match.
+func TestServeHTTPReturns404BelowMinConfidence(t *testing.T) {
+	responseHistory = newResponseDB()
+	Handler = responseHistory
+	defer SetMinConfidence(0)
+
+	AddToHistory(Item{
+		Host:   Host{Value: "example.com"},
+		Method: "GET",
+		Path:   "/accounts",
+	})
+	SetMinConfidence(10.0)
+
+	req := httptest.NewRequest("GET", "http://example.com/widgets", nil)
+	rec := httptest.NewRecorder()
+	Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
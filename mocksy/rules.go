@@ -0,0 +1,164 @@
+package mocksy
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Rule attaches dynamic behavior to a recorded Item. Match is an
+// expression evaluated against the incoming request; an item whose Match
+// rules don't all hold is excluded from FindMatching as a hard filter,
+// before fuzzySort ever sees it. Rewrite is a single "lhs = expression"
+// assignment evaluated against the selected item's Response before it is
+// returned, so captured fixtures can be made dynamic (e.g. echoing a
+// correlation ID) without re-recording. Supported rewrite targets are
+// response.body, response.status and response.header("Name").
+type Rule struct {
+	Match   string
+	Rewrite string
+}
+
+// matches reports whether rule.Match holds for req and body. A rule with
+// an empty Match always matches.
+func (rule Rule) matches(req *http.Request, body []byte) (bool, error) {
+	if rule.Match == "" {
+		return true, nil
+	}
+	out, err := evalExpr(rule.Match, &ruleEnv{request: req, body: body})
+	if err != nil {
+		return false, err
+	}
+	ok, _ := out.(bool)
+	return ok, nil
+}
+
+// applyRewrite evaluates rule.Rewrite, a single "lhs = expression"
+// assignment, and returns resp with lhs updated. A rule with an empty
+// Rewrite returns resp unchanged.
+func (rule Rule) applyRewrite(req *http.Request, body []byte, resp RecordedResponse) (RecordedResponse, error) {
+	if rule.Rewrite == "" {
+		return resp, nil
+	}
+	parts := strings.SplitN(rule.Rewrite, "=", 2)
+	if len(parts) != 2 {
+		return resp, fmt.Errorf("mocksy: malformed rewrite rule %q", rule.Rewrite)
+	}
+	lhs := strings.TrimSpace(parts[0])
+	rhs := strings.TrimSpace(parts[1])
+
+	env := &ruleEnv{request: req, body: body, response: &resp}
+	out, err := evalExpr(rhs, env)
+	if err != nil {
+		return resp, err
+	}
+	value, _ := out.(string)
+
+	target, err := parseRewriteTarget(lhs, env)
+	if err != nil {
+		return resp, err
+	}
+
+	switch target.field {
+	case "body":
+		resp.Body = []byte(value)
+	case "status":
+		if f, ok := out.(float64); ok {
+			resp.Status = int(f)
+			break
+		}
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			return resp, fmt.Errorf("mocksy: rewrite rule %q did not produce a numeric status: %s", rule.Rewrite, err.Error())
+		}
+		resp.Status = status
+	case "header":
+		// resp is a value copy of the item's stored response, but Headers is
+		// a map, so it still aliases the same underlying storage. Clone it
+		// before mutating so a rewrite rule can't corrupt the recorded
+		// fixture (and race with concurrent ServeHTTP calls reading it).
+		if resp.Headers != nil {
+			resp.Headers = resp.Headers.Clone()
+		} else {
+			resp.Headers = make(http.Header)
+		}
+		resp.Headers.Set(target.headerName, value)
+	}
+	return resp, nil
+}
+
+// rewriteTarget is a parsed Rule.Rewrite left-hand side, e.g. "response.body"
+// or `response.header("X-Name")`.
+type rewriteTarget struct {
+	field      string // "body", "status" or "header"
+	headerName string // set only when field == "header"
+}
+
+// parseRewriteTarget parses lhs through the same expression parser used for
+// Rewrite's right-hand side, rather than matching it as a raw string, so a
+// rewrite target is held to the same grammar as every other expression.
+func parseRewriteTarget(lhs string, env *ruleEnv) (rewriteTarget, error) {
+	node, err := parseExpr(lhs)
+	if err != nil {
+		return rewriteTarget{}, fmt.Errorf("mocksy: malformed rewrite target %q: %s", lhs, err.Error())
+	}
+	chain, ok := node.(*chainNode)
+	if !ok || len(chain.segments) != 2 || chain.segments[0].name != "response" {
+		return rewriteTarget{}, fmt.Errorf("mocksy: unsupported rewrite target %q", lhs)
+	}
+	switch field := chain.segments[1]; field.name {
+	case "body", "status":
+		return rewriteTarget{field: field.name}, nil
+	case "header":
+		name, err := stringArg(field.args, 0, env)
+		if err != nil {
+			return rewriteTarget{}, err
+		}
+		return rewriteTarget{field: "header", headerName: name}, nil
+	default:
+		return rewriteTarget{}, fmt.Errorf("mocksy: unsupported rewrite target %q", lhs)
+	}
+}
+
+// filterByRules keeps only the items whose Match rules (if any) hold for
+// the incoming request. This runs as a hard filter before fuzzySort. A
+// rule that fails to evaluate excludes its item rather than admitting it,
+// since a broken Match expression must not silently match everything.
+func filterByRules(items []Item, req *http.Request, body []byte) []Item {
+	filtered := make([]Item, 0, len(items))
+	for _, it := range items {
+		viable := true
+		for _, rule := range it.Rules {
+			ok, err := rule.matches(req, body)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "mocksy: error evaluating match rule %q: %s\n", rule.Match, err.Error())
+				viable = false
+				break
+			}
+			if !ok {
+				viable = false
+				break
+			}
+		}
+		if viable {
+			filtered = append(filtered, it)
+		}
+	}
+	return filtered
+}
+
+// applyRewriteRules runs every Rewrite rule attached to it, in order,
+// against it.Response before the item is returned to the caller.
+func applyRewriteRules(it Item, req *http.Request, body []byte) Item {
+	for _, rule := range it.Rules {
+		resp, err := rule.applyRewrite(req, body, it.Response)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mocksy: error evaluating rewrite rule %q: %s\n", rule.Rewrite, err.Error())
+			continue
+		}
+		it.Response = resp
+	}
+	return it
+}
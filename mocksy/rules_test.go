@@ -0,0 +1,99 @@
+package mocksy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRuleMatchesEvaluatesExpression(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/orders", nil)
+	req.Header.Set("X-Tenant", "acme")
+
+	rule := Rule{Match: `request.header("X-Tenant") == "acme" && request.path == "/orders"`}
+	ok, err := rule.matches(req, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !ok {
+		t.Fatalf("expected rule to match")
+	}
+
+	rule = Rule{Match: `request.header("X-Tenant") == "other-co"`}
+	ok, err = rule.matches(req, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ok {
+		t.Fatalf("expected rule not to match")
+	}
+}
+
+// TestFilterByRulesFailsClosed ensures a broken Match expression excludes
+// its item rather than admitting it, since a hard filter must fail closed.
+func TestFilterByRulesFailsClosed(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/orders", nil)
+	items := []Item{
+		{Rules: []Rule{{Match: `request.nonsense(`}}},
+	}
+	got := filterByRules(items, req, nil)
+	if len(got) != 0 {
+		t.Fatalf("expected broken match rule to exclude the item, got %d candidates", len(got))
+	}
+}
+
+func TestRuleApplyRewriteHeader(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/orders", nil)
+	rule := Rule{Rewrite: `response.header("X-Correlation-Id") = request.header("X-Correlation-Id")`}
+	req.Header.Set("X-Correlation-Id", "abc-123")
+
+	resp, err := rule.applyRewrite(req, nil, RecordedResponse{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := resp.Headers.Get("X-Correlation-Id"); got != "abc-123" {
+		t.Fatalf("expected header to be rewritten to abc-123, got %q", got)
+	}
+}
+
+// TestRuleApplyRewriteHeaderWhitespace exercises the rewrite target going
+// through the full expression parser rather than a raw prefix/suffix match,
+// so it tolerates the same whitespace the right-hand side already does.
+func TestRuleApplyRewriteHeaderWhitespace(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/orders", nil)
+	rule := Rule{Rewrite: `response.header( "X-Correlation-Id" ) = "abc-123"`}
+
+	resp, err := rule.applyRewrite(req, nil, RecordedResponse{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := resp.Headers.Get("X-Correlation-Id"); got != "abc-123" {
+		t.Fatalf("expected header to be rewritten to abc-123, got %q", got)
+	}
+}
+
+func TestEvalExprUnaryMinus(t *testing.T) {
+	out, err := evalExpr("-5", &ruleEnv{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if f, ok := out.(float64); !ok || f != -5 {
+		t.Fatalf("expected -5, got %v", out)
+	}
+
+	if _, err := evalExpr(`-"nope"`, &ruleEnv{}); err == nil {
+		t.Fatalf("expected an error negating a non-numeric operand")
+	}
+}
+
+func TestRuleApplyRewriteBody(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://foo.example.com/orders", nil)
+	rule := Rule{Rewrite: `response.body = replace(response.body, "staging.example.com", request.host)`}
+
+	resp, err := rule.applyRewrite(req, nil, RecordedResponse{Body: []byte("see staging.example.com for docs")})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := string(resp.Body); got != "see foo.example.com for docs" {
+		t.Fatalf("unexpected rewritten body: %q", got)
+	}
+}
@@ -0,0 +1,34 @@
+package mocksy
+
+import "testing"
+
+func TestBodyDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+		{"abc", "abc", 0},
+	}
+	for _, c := range cases {
+		if got := bodyDistance([]byte(c.a), []byte(c.b)); got != c.want {
+			t.Errorf("bodyDistance(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+// TestCandidateScorePrefersCloserBody ensures two candidates with the same
+// length body aren't misranked just because their lengths coincide; the
+// one with fewer edits to the request body should score higher.
+func TestCandidateScorePrefersCloserBody(t *testing.T) {
+	args := compareArgs{Request: []byte("hello world")}
+	closeMatch := Item{Request: RecordedRequest{Value: []byte("hello earth")}}
+	farMatch := Item{Request: RecordedRequest{Value: []byte("xxxxx xxxxx")}}
+
+	closeScore := candidateScore(closeMatch, args, DefaultMatchWeights)
+	farScore := candidateScore(farMatch, args, DefaultMatchWeights)
+	if closeScore <= farScore {
+		t.Fatalf("expected closer body to score higher: close=%f far=%f", closeScore, farScore)
+	}
+}
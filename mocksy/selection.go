@@ -0,0 +1,75 @@
+package mocksy
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// SelectionPolicy decides which candidate to return when FindMatching has
+// more than one item that ranks equally well for an incoming request.
+type SelectionPolicy interface {
+	// Select picks one of candidates for req. candidates is never empty.
+	Select(candidates []Item, req *http.Request) Item
+}
+
+var selectionPolicy SelectionPolicy = FirstMatch{}
+
+// SetSelectionPolicy overrides the policy FindMatching uses to pick among
+// tied candidates.
+func SetSelectionPolicy(p SelectionPolicy) {
+	selectionPolicy = p
+}
+
+// FirstMatch always returns the best-ranked candidate, i.e. candidates[0].
+// This is mocksy's original, default behavior.
+type FirstMatch struct{}
+
+func (FirstMatch) Select(candidates []Item, req *http.Request) Item {
+	return candidates[0]
+}
+
+// Random returns a uniformly random candidate. Useful to simulate a
+// backend whose response varies across retries.
+type Random struct{}
+
+func (Random) Select(candidates []Item, req *http.Request) Item {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// RoundRobin cycles through the candidates recorded for a given
+// "method+path" route, one at a time, so replaying the same endpoint
+// multiple times walks through every response that was captured for it.
+type RoundRobin struct {
+	mu    sync.Mutex
+	index map[string]int
+}
+
+// NewRoundRobin returns a ready-to-use RoundRobin policy.
+func NewRoundRobin() *RoundRobin {
+	return &RoundRobin{index: make(map[string]int)}
+}
+
+func (rr *RoundRobin) Select(candidates []Item, req *http.Request) Item {
+	key := routeKey(req.Method, req.URL.EscapedPath())
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.index == nil {
+		rr.index = make(map[string]int)
+	}
+	i := rr.index[key] % len(candidates)
+	rr.index[key] = i + 1
+	return candidates[i]
+}
+
+// IPHash deterministically picks a candidate based on the caller's
+// RemoteAddr, so the same client always gets the same recorded response.
+type IPHash struct{}
+
+func (IPHash) Select(candidates []Item, req *http.Request) Item {
+	h := fnv.New32a()
+	h.Write([]byte(stripPort(req.RemoteAddr)))
+	return candidates[h.Sum32()%uint32(len(candidates))]
+}
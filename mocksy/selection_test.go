@@ -0,0 +1,46 @@
+package mocksy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestTopTierExcludesNonTiedCandidates ensures a SelectionPolicy never
+// sees a candidate that fuzzySort ranked behind the best score, even when
+// it's handed the whole (sorted) viable list.
+func TestTopTierExcludesNonTiedCandidates(t *testing.T) {
+	args := compareArgs{Method: "GET", Path: "/health", Protocol: "HTTP/1.1", Port: "80"}
+	best1 := Item{Method: "GET", Path: "/health", Protocol: "HTTP/1.1", Port: "80"}
+	best2 := Item{Method: "GET", Path: "/health", Protocol: "HTTP/1.1", Port: "80"}
+	worst := Item{Method: "POST", Path: "/other", Protocol: "HTTP/1.0", Port: "8080"}
+
+	reqs := []Item{best1, best2, worst}
+	tied := topTier(reqs, args, DefaultMatchWeights)
+
+	if len(tied) != 2 {
+		t.Fatalf("expected 2 tied candidates, got %d", len(tied))
+	}
+	for _, it := range tied {
+		if it.Path != "/health" {
+			t.Fatalf("topTier leaked a non-tied candidate: %+v", it)
+		}
+	}
+}
+
+func TestRoundRobinCyclesThroughCandidates(t *testing.T) {
+	rr := NewRoundRobin()
+	req := httptest.NewRequest("GET", "http://example.com/health", nil)
+	candidates := []Item{
+		{Method: "GET", Path: "/health", Response: RecordedResponse{Body: []byte("one")}},
+		{Method: "GET", Path: "/health", Response: RecordedResponse{Body: []byte("two")}},
+	}
+
+	first := rr.Select(candidates, req)
+	second := rr.Select(candidates, req)
+	third := rr.Select(candidates, req)
+
+	if string(first.Response.Body) != "one" || string(second.Response.Body) != "two" || string(third.Response.Body) != "one" {
+		t.Fatalf("expected round-robin cycle one,two,one; got %s,%s,%s",
+			first.Response.Body, second.Response.Body, third.Response.Body)
+	}
+}